@@ -1,37 +1,61 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
+	"github.com/jazeved0/go-example-io/internal/blockcipher"
+	"github.com/jazeved0/go-example-io/internal/concurrent"
+	"github.com/jazeved0/go-example-io/internal/hashing"
 	"github.com/pkg/errors"
 )
 
 var (
-	ioModeFlag     = flag.String("mode", "", "Mode (read, write) to use when running")
-	pathFlag       = flag.String("path", "", "Path of the file to read/write from")
-	iterSleepFlag  = flag.Duration("iter-sleep", 1*time.Millisecond, "Amount of time to sleep between read/write iterations (a single block read/written)")
-	blockCountFlag = flag.Int("blocks", 2048, "Number of blocks to read/write")
-	blockSizeFlag  = flag.Int("block-size", 32768, "The size of each block to read/write")
-	syncWriteFlag  = flag.Bool("sync", false, "Whether to sync at the end of a write operation")
+	ioModeFlag        = flag.String("mode", "", "Mode (read, write, verify, encrypt-write, decrypt-read) to use when running")
+	pathFlag          = flag.String("path", "", "Path of the file to read/write from")
+	iterSleepFlag     = flag.Duration("iter-sleep", 1*time.Millisecond, "Amount of time to sleep between read/write iterations (a single block read/written)")
+	blockCountFlag    = flag.Int("blocks", 2048, "Number of blocks to read/write")
+	blockSizeFlag     = flag.Int("block-size", 32768, "The size of each block to read/write")
+	syncWriteFlag     = flag.Bool("sync", false, "Whether to sync at the end of a write operation")
+	expectedHashFlag  = flag.String("expected-hash", "", "Expected hex-encoded hash to compare against in verify mode")
+	hashFlag          = flag.String("hash", "sha256", fmt.Sprintf("Hash algorithm to use (one of %v)", hashing.Algorithms()))
+	hashModeFlag      = flag.String("hash-mode", "linear", "Hashing strategy to use for read/verify: linear (single hasher) or tree (concurrent Merkle-of-blocks)")
+	hashWorkersFlag   = flag.Int("hash-workers", 1, "Number of worker goroutines hashing blocks concurrently when --hash-mode=tree")
+	pipelineDepthFlag = flag.Int("pipeline-depth", 4, "Number of in-flight blocks buffered between the reader and hash workers when --hash-mode=tree")
+	keyFileFlag       = flag.String("key-file", "", fmt.Sprintf("Path to a raw %d-byte key file to use for --mode=encrypt-write/decrypt-read (mutually exclusive with --passphrase)", blockcipher.KeySize))
+	passphraseFlag    = flag.String("passphrase", "", "Passphrase to derive an encryption key from via scrypt, for --mode=encrypt-write/decrypt-read (mutually exclusive with --key-file)")
+	offsetFlag        = flag.Int64("offset", 0, "Byte offset to seek to before hashing, for --mode=read/verify")
+	lengthFlag        = flag.Int64("length", 0, "Number of bytes to hash starting at --offset, for --mode=read/verify (0 means until EOF)")
 )
 
 type Command struct {
-	mode       string
-	path       string
-	iterSleep  time.Duration
-	blockCount int
-	blockSize  int
-	syncWrite  bool
+	mode          string
+	path          string
+	iterSleep     time.Duration
+	blockCount    int
+	blockSize     int
+	syncWrite     bool
+	expectedHash  string
+	hashName      string
+	newHash       func() hash.Hash
+	hashMode      string
+	hashWorkers   int
+	pipelineDepth int
+	keyFile       string
+	passphrase    string
+	offset        int64
+	length        int64
 }
 
 func main() {
@@ -43,6 +67,30 @@ func main() {
 	if *pathFlag == "" {
 		log.Fatal("--path is required")
 	}
+	newHash, err := hashing.New(*hashFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *hashModeFlag != "linear" && *hashModeFlag != "tree" {
+		log.Fatalf("unknown --hash-mode argument %q", *hashModeFlag)
+	}
+	if *hashWorkersFlag < 1 {
+		log.Fatal("--hash-workers must be at least 1")
+	}
+	if *pipelineDepthFlag < 1 {
+		log.Fatal("--pipeline-depth must be at least 1")
+	}
+	if *ioModeFlag == "encrypt-write" || *ioModeFlag == "decrypt-read" {
+		if (*keyFileFlag == "") == (*passphraseFlag == "") {
+			log.Fatal("exactly one of --key-file or --passphrase is required for --mode=encrypt-write/decrypt-read")
+		}
+	}
+	if *offsetFlag < 0 {
+		log.Fatal("--offset must not be negative")
+	}
+	if *lengthFlag < 0 {
+		log.Fatal("--length must not be negative")
+	}
 
 	ctx := context.Background()
 
@@ -63,15 +111,25 @@ func main() {
 	}()
 
 	command := Command{
-		mode:       *ioModeFlag,
-		path:       *pathFlag,
-		iterSleep:  *iterSleepFlag,
-		blockCount: *blockCountFlag,
-		blockSize:  *blockSizeFlag,
-		syncWrite:  *syncWriteFlag,
+		mode:          *ioModeFlag,
+		path:          *pathFlag,
+		iterSleep:     *iterSleepFlag,
+		blockCount:    *blockCountFlag,
+		blockSize:     *blockSizeFlag,
+		syncWrite:     *syncWriteFlag,
+		expectedHash:  *expectedHashFlag,
+		hashName:      *hashFlag,
+		newHash:       newHash,
+		hashMode:      *hashModeFlag,
+		hashWorkers:   *hashWorkersFlag,
+		pipelineDepth: *pipelineDepthFlag,
+		keyFile:       *keyFileFlag,
+		passphrase:    *passphraseFlag,
+		offset:        *offsetFlag,
+		length:        *lengthFlag,
 	}
 
-	err := command.run(ctx)
+	err = command.run(ctx)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
@@ -88,13 +146,19 @@ func (c *Command) run(ctx context.Context) error {
 		return c.runRead(ctx)
 	case "write":
 		return c.runWrite(ctx)
+	case "verify":
+		return c.runVerify(ctx)
+	case "encrypt-write":
+		return c.runEncryptWrite(ctx)
+	case "decrypt-read":
+		return c.runDecryptRead(ctx)
 	default:
 		return fmt.Errorf("unknown --mode argument %q", c.mode)
 	}
 }
 
-// runRead reads from the given path and computes the SHA-256 digest,
-// printing it out to stdout.
+// runRead reads from the given path and computes the digest with the
+// algorithm selected by --hash, logging it (to stderr) as hex.
 // Reads (c.blockSize) bytes every (c.iterSleep), sleeping in between,
 // until the entire file has been read in.
 func (c *Command) runRead(ctx context.Context) error {
@@ -104,39 +168,196 @@ func (c *Command) runRead(ctx context.Context) error {
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
+	log.Printf("Starting read from %q", c.path)
+	digest, totalRead, err := c.hashFile(ctx, file)
+	if err != nil {
+		return err
+	}
+	log.Printf("Reading finished from %q (%d bytes)", c.path, totalRead)
+
+	// Print the hash out as hex
+	log.Printf("%s hash: %s", c.hashName, hex.EncodeToString(digest))
+	return nil
+}
+
+// runVerify reads from the given path the same way runRead does, but
+// instead of only printing the computed digest, it compares it against
+// (c.expectedHash) and returns an error on mismatch so the process exits
+// non-zero, making the tool usable as a CI integrity check.
+func (c *Command) runVerify(ctx context.Context) error {
+	if c.expectedHash == "" {
+		return errors.New("--expected-hash is required in verify mode")
+	}
+	expected, err := hex.DecodeString(c.expectedHash)
+	if err != nil {
+		return errors.Wrap(err, "--expected-hash is not valid hex")
+	}
+
+	file, err := os.Open(c.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file for reading")
+	}
+	defer file.Close()
+
+	log.Printf("Starting verify of %q", c.path)
+	digest, totalRead, err := c.hashFile(ctx, file)
+	if err != nil {
+		return err
+	}
+	log.Printf("Verify read finished for %q (%d bytes)", c.path, totalRead)
+
+	computedHash := hex.EncodeToString(digest)
+	if !bytes.Equal(digest, expected) {
+		return fmt.Errorf("hash mismatch: expected %s, got %s", c.expectedHash, computedHash)
+	}
+
+	log.Printf("%s hash %s matches --expected-hash", c.hashName, computedHash)
+	return nil
+}
+
+// hashFile returns the digest of file (computed with c.newHash) along with
+// the total number of bytes read, dispatching to the linear or concurrent
+// tree-hash pipeline depending on c.hashMode. If (c.offset) is set, it
+// seeks there before hashing; if (c.length) is set, only that many bytes
+// from (c.offset) are hashed rather than the rest of the file.
+func (c *Command) hashFile(ctx context.Context, file *os.File) ([]byte, int, error) {
+	if c.offset > 0 {
+		if _, err := file.Seek(c.offset, io.SeekStart); err != nil {
+			return nil, 0, errors.Wrap(err, "failed to seek to --offset")
+		}
+	}
+
+	var reader io.Reader = file
+	if c.length > 0 {
+		reader = io.LimitReader(file, c.length)
+	}
+
+	if c.hashMode == "tree" {
+		return c.hashFileTree(ctx, reader)
+	}
+	return c.hashFileLinear(ctx, reader)
+}
+
+// hashFileLinear streams r through a single hashing.Reader and returns the
+// resulting digest along with the total number of bytes read. Reads
+// (c.blockSize) bytes every (c.iterSleep), sleeping in between, until r is
+// exhausted. Uses io.ReadFull so that a short, non-EOF read from r (as can
+// happen with pipes or network mounts) does not get treated as if it had
+// filled a whole block.
+func (c *Command) hashFileLinear(ctx context.Context, r io.Reader) ([]byte, int, error) {
+	hashingReader := hashing.NewReader(r, c.newHash())
 	ticker := time.NewTicker(c.iterSleep)
-	buf := make([]byte, 0, c.blockSize)
+	buf := make([]byte, c.blockSize)
 	totalRead := 0
-	log.Printf("Starting read from %q", c.path)
 	for {
 		// Wait for the interval
 		select {
 		case <-ctx.Done():
-			return errors.Wrap(ctx.Err(), "reading cancelled")
+			return nil, totalRead, errors.Wrap(ctx.Err(), "reading cancelled")
 		case <-ticker.C:
 		}
 
-		bytesRead, err := file.Read(buf[:cap(buf)])
-		if err != nil && err != io.EOF {
-			return errors.Wrap(err, "failed to read segment from file")
-		}
+		bytesRead, err := io.ReadFull(hashingReader, buf)
 		totalRead += bytesRead
 
-		// Add the read bytes to the hash
-		hasher.Write(buf[:bytesRead])
-
-		if err == io.EOF {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		}
+		if err != nil {
+			return nil, totalRead, errors.Wrap(err, "failed to read segment from file")
+		}
 	}
 
-	log.Printf("Reading finished from %q (%d bytes)", c.path, totalRead)
+	return hashingReader.Sum(nil), totalRead, nil
+}
 
-	// Print the hash out as hex
-	computedHash := hex.EncodeToString(hasher.Sum(nil))
-	log.Printf("SHA-256 hash: %s", computedHash)
-	return nil
+// hashFileTree computes the digest of file using a producer/consumer
+// pipeline: a single goroutine reads (c.blockSize) blocks from file at the
+// usual (c.iterSleep) pace into buffers drawn from a pool, and
+// (c.hashWorkers) goroutines drain them (via a channel buffered to
+// c.pipelineDepth) to hash each block independently. The per-block digests
+// are then combined, in block order, into a Merkle tree (hashing.CombineTree)
+// to produce the final root digest. The result is deterministic for a
+// given --block-size and --hash, regardless of --hash-workers. For input
+// of more than one block it differs from the linear digest produced by
+// --hash-mode=linear; for input of at most one block the two are equal.
+func (c *Command) hashFileTree(ctx context.Context, r io.Reader) ([]byte, int, error) {
+	bufPool := concurrent.NewBufferPool(c.blockSize)
+	blocks := make(chan concurrent.Block, c.pipelineDepth)
+
+	type leaf struct {
+		index  int
+		digest []byte
+	}
+	leaves := make(chan leaf, c.pipelineDepth)
+
+	ticker := time.NewTicker(c.iterSleep)
+	totalRead := 0
+	var produceErr error
+
+	go func() {
+		defer close(blocks)
+		for index := 0; ; index++ {
+			select {
+			case <-ctx.Done():
+				produceErr = errors.Wrap(ctx.Err(), "reading cancelled")
+				return
+			case <-ticker.C:
+			}
+
+			buf := bufPool.Get()
+			bytesRead, err := io.ReadFull(r, buf)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				produceErr = errors.Wrap(err, "failed to read segment from file")
+				return
+			}
+			if bytesRead > 0 {
+				totalRead += bytesRead
+				blocks <- concurrent.Block{Data: buf[:bytesRead], Index: index}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < c.hashWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for block := range blocks {
+				h := c.newHash()
+				h.Write(block.Data)
+				leaves <- leaf{index: block.Index, digest: h.Sum(nil)}
+				bufPool.Put(block.Data[:cap(block.Data)])
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(leaves)
+	}()
+
+	collected := make(map[int][]byte)
+	maxIndex := -1
+	for l := range leaves {
+		collected[l.index] = l.digest
+		if l.index > maxIndex {
+			maxIndex = l.index
+		}
+	}
+
+	if produceErr != nil {
+		return nil, totalRead, produceErr
+	}
+
+	ordered := make([][]byte, maxIndex+1)
+	for i := range ordered {
+		ordered[i] = collected[i]
+	}
+
+	return hashing.CombineTree(ordered, c.newHash), totalRead, nil
 }
 
 // runWrite writes to the given path with a sequence of cryptographically random bytes.
@@ -187,6 +408,162 @@ func (c *Command) runWrite(ctx context.Context) error {
 	return nil
 }
 
+// runEncryptWrite writes (c.blockCount) blocks of cryptographically random
+// plaintext to the given path, sealing each block with AES-256-GCM before
+// it reaches disk. Each sealed frame is nonce || ciphertext || tag. If
+// --passphrase is used instead of --key-file, a salt header is written
+// first so the key can be re-derived by runDecryptRead. Seals (c.blockSize)
+// plaintext bytes every (c.iterSleep), sleeping in between as runWrite
+// does, and writes each as a frame of blockcipher.FrameSize(c.blockSize)
+// bytes.
+func (c *Command) runEncryptWrite(ctx context.Context) error {
+	file, err := os.Create(c.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create file for writing")
+	}
+	defer file.Close()
+
+	var salt []byte
+	if c.keyFile == "" {
+		salt, err = blockcipher.NewSalt()
+		if err != nil {
+			return errors.Wrap(err, "failed to generate salt")
+		}
+		if _, err := file.Write(salt); err != nil {
+			return errors.Wrap(err, "failed to write salt header")
+		}
+	}
+
+	key, err := c.loadKey(salt)
+	if err != nil {
+		return err
+	}
+	sealer, err := blockcipher.NewSealer(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize sealer")
+	}
+
+	ticker := time.NewTicker(c.iterSleep)
+	plaintext := make([]byte, c.blockSize)
+	totalWritten := 0
+	log.Printf("Starting encrypted write to %q", c.path)
+	for i := 0; i < c.blockCount; i++ {
+		// Wait for the interval
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "writing cancelled")
+		case <-ticker.C:
+		}
+
+		if err := generateRandomBytes(plaintext); err != nil {
+			return errors.Wrap(err, "failed to generate random bytes to write to file")
+		}
+
+		bytesWritten, err := file.Write(sealer.Seal(plaintext))
+		if err != nil {
+			return errors.Wrap(err, "failed to write sealed frame to file")
+		}
+		totalWritten += bytesWritten
+	}
+
+	// Sync the file if that behavior is enabled
+	if c.syncWrite {
+		if err := file.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync the written file")
+		}
+	}
+
+	log.Printf("Encrypted write finished to %q (%d bytes)", c.path, totalWritten)
+	return nil
+}
+
+// runDecryptRead reads the sealed frames written by runEncryptWrite back
+// from the given path, opening (decrypting and authenticating) each one
+// and feeding the recovered plaintext through the digest algorithm
+// selected by --hash, printing it out at the end the same way runRead
+// does. A GCM authentication failure on any frame is treated as fatal
+// corruption and aborts the read immediately. Reads one frame every
+// (c.iterSleep), sleeping in between.
+func (c *Command) runDecryptRead(ctx context.Context) error {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file for reading")
+	}
+	defer file.Close()
+
+	var salt []byte
+	if c.keyFile == "" {
+		salt = make([]byte, blockcipher.SaltSize)
+		if _, err := io.ReadFull(file, salt); err != nil {
+			return errors.Wrap(err, "failed to read salt header")
+		}
+	}
+
+	key, err := c.loadKey(salt)
+	if err != nil {
+		return err
+	}
+	opener, err := blockcipher.NewOpener(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize opener")
+	}
+
+	hasher := c.newHash()
+	ticker := time.NewTicker(c.iterSleep)
+	frame := make([]byte, blockcipher.FrameSize(c.blockSize))
+	totalRead := 0
+	log.Printf("Starting encrypted read from %q", c.path)
+	for {
+		// Wait for the interval
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "reading cancelled")
+		case <-ticker.C:
+		}
+
+		_, err := io.ReadFull(file, frame)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read ciphertext frame, file may be truncated")
+		}
+
+		plaintext, err := opener.Open(frame)
+		if err != nil {
+			return errors.Wrap(err, "GCM authentication failed, ciphertext frame is corrupt")
+		}
+		hasher.Write(plaintext)
+		totalRead += len(plaintext)
+	}
+
+	log.Printf("Encrypted read finished from %q (%d plaintext bytes)", c.path, totalRead)
+	log.Printf("%s hash: %s", c.hashName, hex.EncodeToString(hasher.Sum(nil)))
+	return nil
+}
+
+// loadKey returns the raw AES-256 key to use for encrypt-write/decrypt-read,
+// either read directly from --key-file or derived from --passphrase and
+// salt via scrypt.
+func (c *Command) loadKey(salt []byte) ([]byte, error) {
+	if c.keyFile != "" {
+		key, err := os.ReadFile(c.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read --key-file")
+		}
+		if len(key) != blockcipher.KeySize {
+			return nil, fmt.Errorf("--key-file must contain exactly %d bytes, got %d", blockcipher.KeySize, len(key))
+		}
+		return key, nil
+	}
+
+	key, err := blockcipher.DeriveKey(c.passphrase, salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key from --passphrase")
+	}
+	return key, nil
+}
+
 // generateRandomBytes fills len(buffer) bytes in the given buffer
 // with cryptographically random bytes.
 func generateRandomBytes(buffer []byte) error {
@@ -0,0 +1,42 @@
+// Package concurrent holds small building blocks for running the hashing
+// pipeline across multiple goroutines: a reusable buffer pool for the
+// producer side, and the block type passed between stages.
+package concurrent
+
+import "sync"
+
+// Block is a single chunk of file data read by the producer, tagged with
+// its position in the stream so consumers can reassemble results in
+// order regardless of which worker processed them.
+type Block struct {
+	Data  []byte
+	Index int
+}
+
+// BufferPool hands out byte slices of a fixed size for the producer to
+// read into, avoiding a fresh allocation for every block.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool returns a BufferPool whose buffers are size bytes long.
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{size: size}
+	p.pool.New = func() interface{} {
+		return make([]byte, p.size)
+	}
+	return p
+}
+
+// Get returns a size-length buffer, either reused from the pool or freshly
+// allocated.
+func (p *BufferPool) Get() []byte {
+	return p.pool.Get().([]byte)
+}
+
+// Put returns a buffer to the pool for reuse. buf must have been obtained
+// from Get and must not be a resliced view into a larger buffer.
+func (p *BufferPool) Put(buf []byte) {
+	p.pool.Put(buf)
+}
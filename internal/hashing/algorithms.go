@@ -0,0 +1,58 @@
+package hashing
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+)
+
+// algorithms maps a user-facing algorithm name to a constructor for a fresh
+// hash.Hash instance. hash.Hash32 and hash.Hash64 (crc32, crc64) satisfy
+// hash.Hash directly, so they need no extra wrapping.
+var algorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+	"blake2s": func() hash.Hash {
+		h, _ := blake2s.New256(nil)
+		return h
+	},
+	"crc32": func() hash.Hash { return crc32.NewIEEE() },
+	"crc64": func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ISO)) },
+}
+
+// New returns a constructor for a fresh hash.Hash for the given algorithm
+// name, or an error if the name is not one of the supported algorithms
+// (see Algorithms).
+func New(name string) (func() hash.Hash, error) {
+	newHash, ok := algorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported --hash algorithm %q (supported: %v)", name, Algorithms())
+	}
+	return newHash, nil
+}
+
+// Algorithms returns the sorted list of supported algorithm names, for use
+// in flag validation and usage messages.
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
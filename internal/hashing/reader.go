@@ -0,0 +1,32 @@
+// Package hashing provides small io.Reader wrappers for computing digests
+// of data as it streams through, rather than hashing a buffer after the
+// fact. The pattern mirrors restic's internal/hashing package.
+package hashing
+
+import (
+	"hash"
+	"io"
+)
+
+// Reader wraps an underlying io.Reader, teeing every byte read from it into
+// a hash.Hash. Once the caller has finished reading, Sum returns the digest
+// of everything that passed through.
+type Reader struct {
+	io.Reader
+	h hash.Hash
+}
+
+// NewReader returns a Reader that reads from r while writing every byte
+// read into h.
+func NewReader(r io.Reader, h hash.Hash) *Reader {
+	return &Reader{
+		Reader: io.TeeReader(r, h),
+		h:      h,
+	}
+}
+
+// Sum appends the current hash to b and returns the resulting slice, as in
+// hash.Hash.Sum. It does not affect the underlying reader.
+func (r *Reader) Sum(b []byte) []byte {
+	return r.h.Sum(b)
+}
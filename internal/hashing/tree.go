@@ -0,0 +1,32 @@
+package hashing
+
+import "hash"
+
+// CombineTree computes a Merkle root over an ordered list of leaf digests,
+// combining pairs as newHash(left || right) level by level. A leaf left
+// unpaired at the end of a level (an odd count) is promoted to the next
+// level unchanged rather than hashed with itself. The result is
+// deterministic for a given leaf ordering, chunk size, and hash algorithm,
+// regardless of how many workers computed the leaves.
+func CombineTree(leaves [][]byte, newHash func() hash.Hash) []byte {
+	if len(leaves) == 0 {
+		return newHash().Sum(nil)
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := newHash()
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return level[0]
+}